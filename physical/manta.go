@@ -1,33 +1,66 @@
 package physical
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/armon/go-metrics"
-	"github.com/joyent/gocommon/client"
-	"github.com/joyent/gomanta/manta"
-	"github.com/joyent/gosign/auth"
+	triton "github.com/joyent/triton-go"
+	"github.com/joyent/triton-go/authentication"
+	tritonerrors "github.com/joyent/triton-go/errors"
+	"github.com/joyent/triton-go/storage"
 	log "github.com/mgutz/logxi/v1"
 )
 
+// mantaEncMagic prefixes the body of every envelope-encrypted object, so
+// Get can tell an encrypted object from plaintext written before encrypt
+// was turned on (or by a version of Vault that doesn't support it).
+var mantaEncMagic = []byte("VLT1")
+
+const (
+	mantaEncVersion    byte = 1
+	mantaEncNonceSize       = 12
+	mantaEncMetaHeader      = "m-vault-enc"
+	mantaEncMetaValue       = "v1"
+)
+
 // MantaBackend is a physical backend that stores data
-// within an Manta client.
+// within a Manta account, using the triton-go SDK.
 type MantaBackend struct {
 	bucket string
-	client *manta.Client
+	client *storage.StorageClient
 	logger log.Logger
+
+	encrypt bool
+	kmsKey  []byte
 }
 
 // newMantaBackend constructs a Manta backend using a pre-existing
 // directory. Credentials can be provided to the backend, sourced
 // from the environment.
+//
+// NOTE: this package only holds the backend implementation. Registering
+// "manta" under the physical-backend factory map (and, for HA, adding it
+// to the server command's allow-list of HA-capable storage types) happens
+// in files that aren't part of this source tree/snapshot, so that wiring
+// isn't done here; whoever assembles the full binary needs to add a
+// "manta": newMantaBackend entry there alongside the other backends. Until
+// that's done, `ha_storage = "manta"` has nowhere to resolve to and HA is
+// not actually enabled by this package on its own.
 func newMantaBackend(conf map[string]string, logger log.Logger) (Backend, error) {
-	endpoint := os.Getenv("MANTA_URL")
+	endpoint := firstEnv("MANTA_URL", "TRITON_URL", "SDC_URL")
 	if endpoint == "" {
 		endpoint = conf["endpoint"]
 		if endpoint == "" {
@@ -35,7 +68,7 @@ func newMantaBackend(conf map[string]string, logger log.Logger) (Backend, error)
 		}
 	}
 
-	user := os.Getenv("MANTA_USER")
+	user := firstEnv("MANTA_USER", "TRITON_ACCOUNT", "SDC_ACCOUNT")
 	if user == "" {
 		user = conf["user"]
 		if user == "" {
@@ -43,7 +76,7 @@ func newMantaBackend(conf map[string]string, logger log.Logger) (Backend, error)
 		}
 	}
 
-	keyid := os.Getenv("MANTA_KEY_ID")
+	keyid := firstEnv("MANTA_KEY_ID", "TRITON_KEY_ID", "SDC_KEY_ID")
 	if keyid == "" {
 		keyid = conf["keyid"]
 		if keyid == "" {
@@ -56,43 +89,170 @@ func newMantaBackend(conf map[string]string, logger log.Logger) (Backend, error)
 		return nil, fmt.Errorf("'path' must be set")
 	}
 
-	privateKeyPath, ok := conf["keypath"]
-	if !ok {
-		privateKeyPath = os.Getenv("HOME") + "/.ssh/id_rsa"
-	}
-
-	privateKey, err := ioutil.ReadFile(privateKeyPath)
+	mantaSigner := selectMantaSigner(conf)
+	signer, err := mantaSigner.Signer(user, keyid)
 	if err != nil {
-		logger.Error(err.Error())
 		return nil, err
 	}
+	logger.Info("manta: using signer: " + mantaSigner.Name())
 
-	authentication, err := auth.NewAuth(user, string(privateKey), "rsa-sha256")
+	client, err := storage.NewClient(&triton.ClientConfig{
+		MantaURL:    endpoint,
+		AccountName: user,
+		Signers:     []authentication.Signer{signer},
+	})
 	if err != nil {
-		logger.Error(err.Error())
-		return nil, err
+		return nil, fmt.Errorf("failed to create Manta storage client: %v", err)
 	}
 
-	credsConfig := &auth.Credentials{
-		UserAuthentication: authentication,
-		MantaKeyId:         keyid,
-		MantaEndpoint:      auth.Endpoint{URL: endpoint},
-	}
+	encrypt := conf["encrypt"] == "true"
 
-	client := client.NewClient(credsConfig.MantaEndpoint.URL, "", credsConfig, nil)
-	mantaClient := manta.New(client)
-	if mantaClient == nil {
-		return nil, fmt.Errorf("fail to create Manta client")
+	var kmsKey []byte
+	if encrypt {
+		kmsKey, err = loadMantaKMSKey(conf)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	m := &MantaBackend{
-		bucket: path,
-		client: mantaClient,
-		logger: logger,
+		bucket:  path,
+		client:  client,
+		logger:  logger,
+		encrypt: encrypt,
+		kmsKey:  kmsKey,
 	}
 	return m, nil
 }
 
+// loadMantaKMSKey resolves the 32-byte AES-256 envelope encryption key from
+// the 'kms_key' config value, the env var it names via 'kms_key_env', or
+// MANTA_KMS_KEY, trying base64 then hex.
+func loadMantaKMSKey(conf map[string]string) ([]byte, error) {
+	raw := conf["kms_key"]
+	if env := conf["kms_key_env"]; env != "" {
+		if v := os.Getenv(env); v != "" {
+			raw = v
+		}
+	}
+	if raw == "" {
+		raw = os.Getenv("MANTA_KMS_KEY")
+	}
+	if raw == "" {
+		return nil, fmt.Errorf("'encrypt' is set but no 'kms_key', 'kms_key_env', or MANTA_KMS_KEY was provided")
+	}
+
+	if key, err := base64.StdEncoding.DecodeString(raw); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if key, err := hex.DecodeString(raw); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	return nil, fmt.Errorf("kms_key must be a 32-byte AES-256 key, base64 or hex encoded")
+}
+
+// MantaSigner builds the triton-go authentication.Signer used to sign
+// requests against Manta, and names itself for operator-facing logs.
+type MantaSigner interface {
+	Signer(user, keyid string) (authentication.Signer, error)
+	Name() string
+}
+
+// selectMantaSigner picks a MantaSigner based on config precedence:
+// explicit key_material > SSH_AUTH_SOCK > keypath > default ~/.ssh/id_rsa.
+func selectMantaSigner(conf map[string]string) MantaSigner {
+	keyMaterial := firstEnv("MANTA_KEY_MATERIAL", "TRITON_KEY_MATERIAL", "SDC_KEY_MATERIAL")
+	if keyMaterial == "" {
+		keyMaterial = conf["key_material"]
+	}
+	if keyMaterial != "" {
+		return &PrivateKeySigner{KeyMaterial: keyMaterial}
+	}
+
+	if conf["use_ssh_agent"] == "true" || os.Getenv("SSH_AUTH_SOCK") != "" {
+		return &SSHAgentSigner{}
+	}
+
+	keypath, ok := conf["keypath"]
+	if !ok {
+		keypath = os.Getenv("HOME") + "/.ssh/id_rsa"
+	}
+	return &FileSigner{Path: keypath}
+}
+
+// PrivateKeySigner signs with a PEM private key supplied directly via
+// config or the environment, without touching the filesystem.
+type PrivateKeySigner struct {
+	KeyMaterial string
+}
+
+func (s *PrivateKeySigner) Signer(user, keyid string) (authentication.Signer, error) {
+	if strings.Contains(s.KeyMaterial, "Proc-Type: 4,ENCRYPTED") {
+		return nil, fmt.Errorf("manta private key is passphrase-encrypted; use 'use_ssh_agent' " +
+			"or provide an unencrypted key via 'key_material'")
+	}
+
+	return authentication.NewPrivateKeySigner(authentication.PrivateKeySignerInput{
+		KeyID:              keyid,
+		PrivateKeyMaterial: []byte(s.KeyMaterial),
+		AccountName:        user,
+	})
+}
+
+func (s *PrivateKeySigner) Name() string {
+	return "key_material"
+}
+
+// SSHAgentSigner delegates signing to the agent listening on
+// SSH_AUTH_SOCK, so passphrase-encrypted keys work without prompting.
+type SSHAgentSigner struct{}
+
+func (s *SSHAgentSigner) Signer(user, keyid string) (authentication.Signer, error) {
+	return authentication.NewSSHAgentSigner(authentication.SSHAgentSignerInput{
+		KeyID:       keyid,
+		AccountName: user,
+	})
+}
+
+func (s *SSHAgentSigner) Name() string {
+	return "ssh-agent"
+}
+
+// FileSigner reads a PEM private key off disk. This is the original
+// $HOME/.ssh/id_rsa behavior, kept for back-compat with existing configs.
+type FileSigner struct {
+	Path string
+}
+
+func (s *FileSigner) Signer(user, keyid string) (authentication.Signer, error) {
+	raw, err := ioutil.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manta private key at %q: %v", s.Path, err)
+	}
+
+	return (&PrivateKeySigner{KeyMaterial: string(raw)}).Signer(user, keyid)
+}
+
+func (s *FileSigner) Name() string {
+	return fmt.Sprintf("file:%s", s.Path)
+}
+
+// firstEnv returns the value of the first set environment variable in names.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// bytesReader adapts a byte slice to the io.ReadSeeker the storage client
+// expects for object bodies.
+func bytesReader(b []byte) *bytes.Reader {
+	return bytes.NewReader(b)
+}
+
 // Put is used to insert or update an entry
 func (m *MantaBackend) Put(entry *Entry) error {
 	defer metrics.MeasureSince([]string{"manta", "put"}, time.Now())
@@ -100,7 +260,23 @@ func (m *MantaBackend) Put(entry *Entry) error {
 	dir, objectName := m.getPathAndObjectName(entry.Key)
 	m.makeDir(dir, objectName)
 
-	err := m.client.PutObject(dir, objectName, entry.Value)
+	value := entry.Value
+	input := &storage.PutObjectInput{
+		ObjectPath: path.Join(dir, objectName),
+	}
+
+	if m.encrypt {
+		sealed, err := m.seal(value)
+		if err != nil {
+			return err
+		}
+		value = sealed
+		input.Headers = map[string]string{mantaEncMetaHeader: mantaEncMetaValue}
+	}
+
+	input.ObjectReader = bytesReader(value)
+
+	err := m.client.Objects().Put(context.Background(), input)
 	if err != nil {
 		return err
 	}
@@ -114,37 +290,164 @@ func (m *MantaBackend) Get(key string) (*Entry, error) {
 
 	dir, objectName := m.getPathAndObjectName(key)
 
-	resp, err := m.client.GetObject(dir, objectName)
+	resp, err := m.client.Objects().Get(context.Background(), &storage.GetObjectInput{
+		ObjectPath: path.Join(dir, objectName),
+	})
 	if err != nil {
-		if strings.Contains(err.Error(), "ResourceNotFound") {
+		if tritonerrors.IsResourceNotFound(err) {
 			return nil, nil
 		}
 		return nil, err
 	}
-	if resp == nil {
+	if resp == nil || resp.ObjectReader == nil {
 		return nil, fmt.Errorf("got nil response from manta but no error")
 	}
+	defer resp.ObjectReader.Close()
+
+	value, err := ioutil.ReadAll(resp.ObjectReader)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.encrypt {
+		if !bytes.HasPrefix(value, mantaEncMagic) {
+			return nil, fmt.Errorf("manta: object %q is not encrypted but 'encrypt' is enabled; "+
+				"refusing to return it silently, run the migrate helper first", key)
+		}
+		value, err = m.unseal(value)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	ent := &Entry{
 		Key:   key,
-		Value: resp,
+		Value: value,
 	}
 
 	return ent, nil
 }
 
+// seal envelope-encrypts value under the configured KMS key, returning
+// magic || version || nonce || ciphertext.
+func (m *MantaBackend) seal(value []byte) ([]byte, error) {
+	block, err := aes.NewCipher(m.kmsKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, mantaEncNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, value, nil)
+
+	out := make([]byte, 0, len(mantaEncMagic)+1+len(nonce)+len(ciphertext))
+	out = append(out, mantaEncMagic...)
+	out = append(out, mantaEncVersion)
+	out = append(out, nonce...)
+	out = append(out, ciphertext...)
+	return out, nil
+}
+
+// unseal reverses seal, validating the magic/version header first.
+func (m *MantaBackend) unseal(raw []byte) ([]byte, error) {
+	if !m.encrypt {
+		return nil, fmt.Errorf("manta: found an encrypted object but 'encrypt' is not enabled")
+	}
+
+	header := len(mantaEncMagic) + 1
+	if len(raw) < header+mantaEncNonceSize {
+		return nil, fmt.Errorf("manta: encrypted object is truncated")
+	}
+	if !bytes.HasPrefix(raw, mantaEncMagic) {
+		return nil, fmt.Errorf("manta: object is missing the envelope encryption magic prefix")
+	}
+	if raw[len(mantaEncMagic)] != mantaEncVersion {
+		return nil, fmt.Errorf("manta: unsupported envelope encryption version %d", raw[len(mantaEncMagic)])
+	}
+
+	nonce := raw[header : header+mantaEncNonceSize]
+	ciphertext := raw[header+mantaEncNonceSize:]
+
+	block, err := aes.NewCipher(m.kmsKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// Migrate walks every object under prefix and rewrites any that are still
+// plaintext, so existing data can be brought under envelope encryption
+// without a separate offline tool.
+func (m *MantaBackend) Migrate(prefix string) error {
+	if !m.encrypt {
+		return fmt.Errorf("manta: 'encrypt' must be enabled to migrate objects")
+	}
+
+	keys, err := m.List(prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		full := path.Join(prefix, key)
+		if strings.HasSuffix(key, "/") {
+			if err := m.Migrate(full); err != nil {
+				return err
+			}
+			continue
+		}
+
+		dir, objectName := m.getPathAndObjectName(full)
+		resp, err := m.client.Objects().Get(context.Background(), &storage.GetObjectInput{
+			ObjectPath: path.Join(dir, objectName),
+		})
+		if err != nil {
+			return err
+		}
+		value, err := ioutil.ReadAll(resp.ObjectReader)
+		resp.ObjectReader.Close()
+		if err != nil {
+			return err
+		}
+
+		if bytes.HasPrefix(value, mantaEncMagic) {
+			continue
+		}
+
+		if err := m.Put(&Entry{Key: full, Value: value}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // Delete is used to permanently delete an entry
 func (m *MantaBackend) Delete(key string) error {
 	defer metrics.MeasureSince([]string{"manta", "delete"}, time.Now())
 
 	dir, objectName := m.getPathAndObjectName(key)
 
-	err := m.client.DeleteObject(dir, objectName)
+	err := m.client.Objects().Delete(context.Background(), &storage.DeleteObjectInput{
+		ObjectPath: path.Join(dir, objectName),
+	})
 	if err != nil {
-		if strings.Contains(err.Error(), "ResourceNotFound") {
+		if tritonerrors.IsResourceNotFound(err) {
 			return nil
 		} else if strings.Contains(err.Error(), "DirectoryNotEmpty") {
-			m.deleteDirectory(key)
+			m.deleteDirectory(path.Join(m.bucket, key))
 			return nil
 		}
 		return err
@@ -153,19 +456,72 @@ func (m *MantaBackend) Delete(key string) error {
 	return nil
 }
 
+// mantaListPageSize is the page size requested per ListDirectory call.
+// The Manta API defaults to 256 entries per page if unset, which
+// silently truncates larger directories.
+const mantaListPageSize = 1024
+
 // List is used to list all the keys under a given
 // prefix, up to the next prefix.
 func (m *MantaBackend) List(prefix string) ([]string, error) {
 	defer metrics.MeasureSince([]string{"manta", "list"}, time.Now())
 
-	entries, err := m.client.ListDirectory(path.Join(m.bucket, prefix), manta.ListDirectoryOpts{})
-	if err != nil {
-		return nil, nil
-	}
+	dir := path.Join(m.bucket, prefix)
+
+	return mantaListPaginated(mantaListPageSize, func(marker string) ([]*storage.DirectoryEntry, error) {
+		input := &storage.ListDirectoryInput{
+			DirectoryName: dir,
+			Limit:         mantaListPageSize,
+		}
+		if marker != "" {
+			input.Marker = marker
+		}
 
+		resp, err := m.client.Dir().List(context.Background(), input)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			return nil, nil
+		}
+		return resp.Entries, nil
+	})
+}
+
+// mantaListPaginated drives marker-based pagination against fetch, a page
+// at a time. Manta's marker is inclusive: every page after the first
+// re-returns the marker entry as its first element, so that entry is
+// dropped before appending to the result.
+func mantaListPaginated(pageSize int, fetch func(marker string) ([]*storage.DirectoryEntry, error)) ([]string, error) {
 	objects := []string{}
-	for _, entry := range entries {
-		objects = append(objects, entry.Name)
+	marker := ""
+
+	for {
+		entries, err := fetch(marker)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) == 0 {
+			break
+		}
+
+		page := entries
+		if marker != "" && page[0].Name == marker {
+			page = page[1:]
+		}
+
+		for _, entry := range page {
+			name := entry.Name
+			if entry.Type == "directory" {
+				name += "/"
+			}
+			objects = append(objects, name)
+		}
+
+		if len(entries) < pageSize {
+			break
+		}
+		marker = entries[len(entries)-1].Name
 	}
 
 	return objects, nil
@@ -175,21 +531,21 @@ func (m *MantaBackend) makeDir(dir string, file string) error {
 	d, p := path.Split(dir)
 	if d == "" {
 		m.logger.Debug("root dir : " + p)
-		err := m.client.PutDirectory(p)
+		err := m.client.Dir().Put(context.Background(), &storage.PutDirectoryInput{DirectoryName: p})
 		if err != nil {
 			m.logger.Error(err.Error())
 		}
 	} else {
 		m.logger.Debug(d + p)
 
-		err := m.client.PutDirectory(d + p)
+		err := m.client.Dir().Put(context.Background(), &storage.PutDirectoryInput{DirectoryName: d + p})
 		if err == nil {
 			return nil
 		}
 		if strings.Contains(err.Error(), "DirectoryDoesNotExist") {
 			d := path.Clean(d)
 			m.makeDir(d, p)
-			if err := m.client.PutDirectory(path.Join(d, p)); err != nil {
+			if err := m.client.Dir().Put(context.Background(), &storage.PutDirectoryInput{DirectoryName: path.Join(d, p)}); err != nil {
 				m.logger.Error(err.Error())
 			}
 		}
@@ -197,17 +553,60 @@ func (m *MantaBackend) makeDir(dir string, file string) error {
 	return nil
 }
 
+// mantaDeleteConcurrency bounds how many DeleteObject calls run in
+// parallel while tearing down a directory, since Manta's per-request
+// latency (not local CPU) dominates deletion time.
+const mantaDeleteConcurrency = 8
+
 func (m *MantaBackend) deleteDirectory(dir string) {
-	listResp, _ := m.client.ListDirectory(dir, manta.ListDirectoryOpts{})
-	for _, item := range listResp {
-		if item.Type == "directory" {
-			subDir := path.Join(dir, item.Name)
-			m.deleteDirectory(subDir)
-			m.client.DeleteDirectory(subDir)
-		} else {
-			m.client.DeleteObject(dir, item.Name)
+	sem := make(chan struct{}, mantaDeleteConcurrency)
+	var wg sync.WaitGroup
+	marker := ""
+
+	for {
+		input := &storage.ListDirectoryInput{
+			DirectoryName: dir,
+			Limit:         mantaListPageSize,
+		}
+		if marker != "" {
+			input.Marker = marker
+		}
+
+		listResp, _ := m.client.Dir().List(context.Background(), input)
+		if listResp == nil || len(listResp.Entries) == 0 {
+			break
+		}
+
+		page := listResp.Entries
+		if marker != "" && page[0].Name == marker {
+			page = page[1:]
+		}
+
+		for _, item := range page {
+			if item.Type == "directory" {
+				subDir := path.Join(dir, item.Name)
+				m.deleteDirectory(subDir)
+				m.client.Dir().Delete(context.Background(), &storage.DeleteDirectoryInput{DirectoryName: subDir})
+				continue
+			}
+
+			objectPath := path.Join(dir, item.Name)
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				m.client.Objects().Delete(context.Background(), &storage.DeleteObjectInput{ObjectPath: objectPath})
+			}()
 		}
+
+		if len(listResp.Entries) < mantaListPageSize {
+			break
+		}
+		marker = listResp.Entries[len(listResp.Entries)-1].Name
 	}
+
+	wg.Wait()
 }
 
 func (m *MantaBackend) getPathAndObjectName(rawPath string) (string, string) {
@@ -0,0 +1,64 @@
+package physical
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/logformat"
+	log "github.com/mgutz/logxi/v1"
+)
+
+// BenchmarkMantaBackend_deleteDirectory measures full-bucket teardown of a
+// directory with many entries, to make sure the bounded worker pool in
+// deleteDirectory actually helps once Manta's per-request latency (not
+// local CPU) is the bottleneck.
+func BenchmarkMantaBackend_deleteDirectory(b *testing.B) {
+	endpoint := os.Getenv("MANTA_URL")
+	user := os.Getenv("MANTA_USER")
+	keyid := os.Getenv("MANTA_KEY_ID")
+	if endpoint == "" || user == "" || keyid == "" {
+		b.SkipNow()
+	}
+
+	logger := logformat.NewVaultLogger(log.LevelTrace)
+	backend, err := NewBackend("manta", logger, map[string]string{
+		"endpoint": endpoint,
+		"keyid":    keyid,
+		"user":     user,
+		"path":     fmt.Sprintf("vault-manta-bench-%d", b.N),
+	})
+	if err != nil {
+		b.Fatalf("err: %v", err)
+	}
+
+	const objectCount = 2000
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		for j := 0; j < objectCount; j++ {
+			entry := &Entry{
+				Key:   fmt.Sprintf("bench/object-%d", j),
+				Value: []byte("benchmark value"),
+			}
+			if err := backend.Put(entry); err != nil {
+				b.Fatalf("err: %v", err)
+			}
+		}
+		b.StartTimer()
+
+		if err := backend.Delete("bench"); err != nil {
+			b.Fatalf("err: %v", err)
+		}
+
+		b.StopTimer()
+		remaining, err := backend.List("bench/")
+		if err != nil {
+			b.Fatalf("err: %v", err)
+		}
+		if len(remaining) != 0 {
+			b.Fatalf("expected \"bench\" to be empty after Delete, got %d entries", len(remaining))
+		}
+		b.StartTimer()
+	}
+}
@@ -0,0 +1,69 @@
+package physical
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/joyent/triton-go/storage"
+)
+
+// TestMantaListPaginated_crossesPageBoundary simulates Manta's inclusive
+// marker: each page after the first re-returns the marker entry as its
+// first element. A naive implementation would duplicate that entry in
+// the result.
+func TestMantaListPaginated_crossesPageBoundary(t *testing.T) {
+	pages := [][]*storage.DirectoryEntry{
+		{
+			{Name: "a", Type: "object"},
+			{Name: "b", Type: "object"},
+		},
+		{
+			{Name: "b", Type: "object"}, // inclusive marker repeat
+			{Name: "c", Type: "directory"},
+		},
+		{
+			{Name: "c", Type: "directory"}, // inclusive marker repeat
+		},
+	}
+
+	var markers []string
+	calls := 0
+	fetch := func(marker string) ([]*storage.DirectoryEntry, error) {
+		markers = append(markers, marker)
+		if calls >= len(pages) {
+			return nil, nil
+		}
+		p := pages[calls]
+		calls++
+		return p, nil
+	}
+
+	got, err := mantaListPaginated(2, fetch)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	want := []string{"a", "b", "c/"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if markers[0] != "" {
+		t.Fatalf("expected first fetch to use an empty marker, got %q", markers[0])
+	}
+	if markers[1] != "b" || markers[2] != "c" {
+		t.Fatalf("expected markers to track the last entry of each full page, got %v", markers)
+	}
+}
+
+// TestMantaListPaginated_propagatesError ensures a backend failure isn't
+// reported as an empty, successful list.
+func TestMantaListPaginated_propagatesError(t *testing.T) {
+	wantErr := fmt.Errorf("manta is down")
+	_, err := mantaListPaginated(2, func(marker string) ([]*storage.DirectoryEntry, error) {
+		return nil, wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("expected %v, got %v", wantErr, err)
+	}
+}
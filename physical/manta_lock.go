@@ -0,0 +1,319 @@
+package physical
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-uuid"
+	tritonerrors "github.com/joyent/triton-go/errors"
+	"github.com/joyent/triton-go/storage"
+)
+
+const (
+	// MantaLockTTL is how long a lock is valid for before another
+	// contender is allowed to steal it.
+	MantaLockTTL = 15 * time.Second
+
+	// MantaLockRenewInterval is how often the lock holder refreshes
+	// the expiry on the lock object.
+	MantaLockRenewInterval = 5 * time.Second
+
+	// MantaLockRetryInterval is the base interval between acquisition
+	// attempts while the lock is held by someone else.
+	MantaLockRetryInterval = 1 * time.Second
+)
+
+// errMantaLockConflict is returned internally when a conditional PUT loses
+// its race against another contender; it never escapes to callers of Lock.
+var errMantaLockConflict = fmt.Errorf("manta: lock precondition failed")
+
+// mantaLockEntry is the JSON payload stored in the lock object.
+type mantaLockEntry struct {
+	Who     string    `json:"who"`
+	Created time.Time `json:"created"`
+	Expires time.Time `json:"expires"`
+	UUID    string    `json:"uuid"`
+}
+
+// mantaLockStore is the narrow surface MantaLock needs from the backend:
+// conditional reads/writes of the lock object by ETag, independent of
+// regular Put/Get so the acquisition race can be tested without a real
+// Manta endpoint.
+type mantaLockStore interface {
+	getLockMeta(key string) (*mantaLockEntry, string, error)
+	putLockConditional(key string, entry *mantaLockEntry, etag string, createOnly bool) error
+	deleteLock(key string) error
+}
+
+// MantaLock implements physical.Lock using a lock file stored alongside
+// the backend's other objects. Acquisition is a single conditional PUT:
+// a create-only PUT (If-None-Match: "*") when no lock object exists yet,
+// or an If-Match PUT against the ETag last read when stealing an expired
+// lock. Manta rejects the PUT outright if another contender won the
+// race, so there is no read-your-write check to get wrong.
+type MantaLock struct {
+	backend mantaLockStore
+	key     string
+	value   string
+
+	identity string
+	etag     string
+
+	l        sync.Mutex
+	held     bool
+	leaderCh chan struct{}
+	stopCh   chan struct{}
+}
+
+// LockWith is used for mutual exclusion based on the given key.
+func (m *MantaBackend) LockWith(key, value string) (Lock, error) {
+	return &MantaLock{
+		backend: m,
+		key:     key,
+		value:   value,
+	}, nil
+}
+
+// HAEnabled indicates whether the Manta backend supports HA.
+func (m *MantaBackend) HAEnabled() bool {
+	return true
+}
+
+// Lock attempts to acquire the lock, blocking until it succeeds or stopCh
+// is closed. The returned channel is closed if leadership is lost.
+func (l *MantaLock) Lock(stopCh <-chan struct{}) (<-chan struct{}, error) {
+	l.l.Lock()
+	defer l.l.Unlock()
+	if l.held {
+		return nil, fmt.Errorf("lock already held")
+	}
+
+	identity, err := uuid.GenerateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate lock identity: %v", err)
+	}
+	l.identity = identity
+
+	for {
+		won, err := l.tryAcquire()
+		if err != nil {
+			return nil, err
+		}
+		if won {
+			break
+		}
+
+		jitter := time.Duration(rand.Int63n(int64(MantaLockRetryInterval)))
+		select {
+		case <-time.After(MantaLockRetryInterval + jitter):
+		case <-stopCh:
+			return nil, nil
+		}
+	}
+
+	l.held = true
+	l.stopCh = make(chan struct{})
+	leaderCh := make(chan struct{})
+	l.leaderCh = leaderCh
+
+	go l.renew(leaderCh, l.stopCh)
+	go func() {
+		select {
+		case <-stopCh:
+			l.Unlock()
+		case <-l.stopCh:
+		}
+	}()
+
+	return leaderCh, nil
+}
+
+// tryAcquire performs a single conditional-PUT acquisition attempt,
+// returning true if this contender ends up holding the lock. Manta's
+// ETag precondition makes this atomic: only one of any number of
+// concurrent callers can win the PUT for a given (key, etag) pair.
+func (l *MantaLock) tryAcquire() (bool, error) {
+	existing, etag, err := l.backend.getLockMeta(l.lockPath())
+	if err != nil {
+		return false, err
+	}
+	if existing != nil && existing.UUID != l.identity && time.Now().Before(existing.Expires) {
+		return false, nil
+	}
+
+	entry := &mantaLockEntry{
+		Who:     l.value,
+		Created: time.Now(),
+		Expires: time.Now().Add(MantaLockTTL),
+		UUID:    l.identity,
+	}
+
+	// No object yet: create-only. An object exists (ours or merely
+	// expired): overwrite only if it still matches what we just read.
+	createOnly := existing == nil
+	if err := l.backend.putLockConditional(l.lockPath(), entry, etag, createOnly); err != nil {
+		if err == errMantaLockConflict {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if _, newEtag, err := l.backend.getLockMeta(l.lockPath()); err == nil {
+		l.etag = newEtag
+	}
+
+	return true, nil
+}
+
+// renew periodically refreshes the lock's expiry until told to stop.
+func (l *MantaLock) renew(leaderCh chan struct{}, stopCh chan struct{}) {
+	ticker := time.NewTicker(MantaLockRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			entry := &mantaLockEntry{
+				Who:     l.value,
+				Created: time.Now(),
+				Expires: time.Now().Add(MantaLockTTL),
+				UUID:    l.identity,
+			}
+			if err := l.backend.putLockConditional(l.lockPath(), entry, l.etag, false); err != nil {
+				// Lost the lock (stolen or backend failure either way); give up leadership.
+				close(leaderCh)
+				return
+			}
+			if _, newEtag, err := l.backend.getLockMeta(l.lockPath()); err == nil {
+				l.etag = newEtag
+			}
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+// Unlock releases the lock if it is currently held.
+func (l *MantaLock) Unlock() error {
+	l.l.Lock()
+	defer l.l.Unlock()
+	if !l.held {
+		return nil
+	}
+
+	close(l.stopCh)
+	l.held = false
+
+	existing, _, err := l.backend.getLockMeta(l.lockPath())
+	if err != nil {
+		return err
+	}
+	if existing == nil || existing.UUID != l.identity {
+		return nil
+	}
+
+	return l.backend.deleteLock(l.lockPath())
+}
+
+// Value returns whether the lock is held and by whom.
+func (l *MantaLock) Value() (bool, string, error) {
+	entry, _, err := l.backend.getLockMeta(l.lockPath())
+	if err != nil {
+		return false, "", err
+	}
+	if entry == nil || time.Now().After(entry.Expires) {
+		return false, "", nil
+	}
+	return true, entry.Who, nil
+}
+
+func (l *MantaLock) lockPath() string {
+	return path.Join(l.key + ".lock")
+}
+
+// getLockMeta reads the lock object and its current ETag, used as the
+// If-Match precondition for the next conditional write.
+func (m *MantaBackend) getLockMeta(key string) (*mantaLockEntry, string, error) {
+	dir, objectName := m.getPathAndObjectName(key)
+
+	resp, err := m.client.Objects().Get(context.Background(), &storage.GetObjectInput{
+		ObjectPath: path.Join(dir, objectName),
+	})
+	if err != nil {
+		if tritonerrors.IsResourceNotFound(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	if resp == nil || resp.ObjectReader == nil {
+		return nil, "", fmt.Errorf("got nil response from manta but no error")
+	}
+	defer resp.ObjectReader.Close()
+
+	raw, err := ioutil.ReadAll(resp.ObjectReader)
+	if err != nil {
+		return nil, "", err
+	}
+
+	entry := &mantaLockEntry{}
+	if err := json.Unmarshal(raw, entry); err != nil {
+		return nil, "", fmt.Errorf("failed to decode manta lock entry: %v", err)
+	}
+	return entry, resp.ETag, nil
+}
+
+// putLockConditional writes the lock object guarded by a Manta ETag
+// precondition: If-None-Match: "*" to create it only if absent, or
+// If-Match: etag to replace it only if it hasn't changed since it was
+// read. Either precondition failing returns errMantaLockConflict.
+func (m *MantaBackend) putLockConditional(key string, entry *mantaLockEntry, etag string, createOnly bool) error {
+	raw, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	dir, objectName := m.getPathAndObjectName(key)
+	m.makeDir(dir, objectName)
+
+	headers := map[string]string{}
+	if createOnly {
+		headers["If-None-Match"] = "*"
+	} else {
+		headers["If-Match"] = etag
+	}
+
+	err = m.client.Objects().Put(context.Background(), &storage.PutObjectInput{
+		ObjectPath:   path.Join(dir, objectName),
+		ObjectReader: bytesReader(raw),
+		Headers:      headers,
+	})
+	if err != nil {
+		if isMantaPreconditionFailed(err) {
+			return errMantaLockConflict
+		}
+		return err
+	}
+	return nil
+}
+
+// deleteLock removes the lock object.
+func (m *MantaBackend) deleteLock(key string) error {
+	if key == "" {
+		return nil
+	}
+	return m.Delete(key)
+}
+
+// isMantaPreconditionFailed reports whether err is Manta's rejection of
+// an If-Match/If-None-Match precondition, i.e. we lost the race.
+func isMantaPreconditionFailed(err error) bool {
+	return strings.Contains(err.Error(), "PreconditionFailed") ||
+		strings.Contains(err.Error(), "ETagConflict")
+}
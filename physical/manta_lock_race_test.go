@@ -0,0 +1,131 @@
+package physical
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeLockStore is an in-memory mantaLockStore that enforces the same
+// ETag precondition semantics a real Manta conditional PUT would, so the
+// acquisition race in MantaLock can be exercised without a live endpoint.
+type fakeLockStore struct {
+	mu    sync.Mutex
+	entry *mantaLockEntry
+	etag  string
+	seq   int
+}
+
+func (f *fakeLockStore) getLockMeta(key string) (*mantaLockEntry, string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.entry, f.etag, nil
+}
+
+func (f *fakeLockStore) putLockConditional(key string, entry *mantaLockEntry, etag string, createOnly bool) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if createOnly {
+		if f.entry != nil {
+			return errMantaLockConflict
+		}
+	} else if f.etag != etag {
+		return errMantaLockConflict
+	}
+
+	f.seq++
+	f.entry = entry
+	f.etag = fmt.Sprintf("etag-%d", f.seq)
+	return nil
+}
+
+func (f *fakeLockStore) deleteLock(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entry = nil
+	f.etag = ""
+	return nil
+}
+
+// TestMantaLock_tryAcquireHasOneWinner hammers tryAcquire, the single
+// conditional-PUT operation Lock loops on, with many contenders racing
+// for the same key at once against the in-memory lock store. Exactly one
+// should come away holding the lock, proving the conditional PUT (rather
+// than a GET-then-PUT read-back) is what provides mutual exclusion.
+func TestMantaLock_tryAcquireHasOneWinner(t *testing.T) {
+	store := &fakeLockStore{}
+
+	const contenders = 25
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	winners := 0
+
+	for i := 0; i < contenders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			lock := &MantaLock{
+				backend: store,
+				key:     "test/lock",
+				value:   fmt.Sprintf("node-%d", i),
+			}
+			lock.identity = fmt.Sprintf("identity-%d", i)
+
+			won, err := lock.tryAcquire()
+			if err != nil {
+				t.Errorf("err: %v", err)
+				return
+			}
+			if won {
+				mu.Lock()
+				winners++
+				mu.Unlock()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	if winners != 1 {
+		t.Fatalf("expected exactly one winner among %d concurrent contenders, got %d", contenders, winners)
+	}
+
+	held, who, err := (&MantaLock{backend: store, key: "test/lock"}).Value()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !held {
+		t.Fatalf("expected the lock to be held after the race")
+	}
+	_ = who
+}
+
+// TestMantaLock_LockThenContend exercises the public Lock API: the first
+// caller should win outright, and a second contender racing for the same
+// still-held key must not also come away with leadership.
+func TestMantaLock_LockThenContend(t *testing.T) {
+	store := &fakeLockStore{}
+
+	lockA := &MantaLock{backend: store, key: "test/lock", value: "a"}
+	stopA := make(chan struct{})
+	defer close(stopA)
+
+	leaderA, err := lockA.Lock(stopA)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if leaderA == nil {
+		t.Fatalf("expected lockA to win the uncontested lock")
+	}
+
+	lockB := &MantaLock{backend: store, key: "test/lock", value: "b"}
+	wonB, err := lockB.tryAcquire()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if wonB {
+		t.Fatalf("expected lockB to lose while lockA still holds the lock")
+	}
+}
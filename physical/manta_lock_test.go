@@ -0,0 +1,113 @@
+package physical
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/helper/logformat"
+	log "github.com/mgutz/logxi/v1"
+)
+
+// TestMantaBackend_LockWith exercises two clients racing for the same
+// lock key, mirroring the Terraform Manta provider's TestBackendLocked
+// pattern: only one of the two should win, and releasing it should let
+// the other acquire it in turn.
+func TestMantaBackend_LockWith(t *testing.T) {
+	endpoint := os.Getenv("MANTA_URL")
+	user := os.Getenv("MANTA_USER")
+	keyid := os.Getenv("MANTA_KEY_ID")
+	if endpoint == "" || user == "" || keyid == "" {
+		t.SkipNow()
+	}
+
+	b := testMantaBackend(t)
+
+	ha, ok := b.(HABackend)
+	if !ok {
+		t.Fatalf("manta backend does not implement HABackend")
+	}
+	if !ha.HAEnabled() {
+		t.Fatalf("expected manta backend to have HA enabled")
+	}
+
+	lockA, err := ha.LockWith("test/lock", "a")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	lockB, err := ha.LockWith("test/lock", "b")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	stopA := make(chan struct{})
+	leaderA, err := lockA.Lock(stopA)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if leaderA == nil {
+		t.Fatalf("expected lockA to win")
+	}
+
+	stopB := make(chan struct{})
+	close(stopB)
+	leaderB, err := lockB.Lock(stopB)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if leaderB != nil {
+		t.Fatalf("expected lockB to not win while lockA holds the lock")
+	}
+
+	held, who, err := lockA.Value()
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !held || who != "a" {
+		t.Fatalf("expected lock to be held by 'a', got held=%v who=%v", held, who)
+	}
+
+	if err := lockA.Unlock(); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	stopC := make(chan struct{})
+	defer close(stopC)
+	go func() {
+		time.Sleep(5 * time.Second)
+		close(stopC)
+	}()
+
+	leaderC, err := lockB.Lock(stopC)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if leaderC == nil {
+		t.Fatalf("expected lockB to win after lockA released")
+	}
+	lockB.Unlock()
+}
+
+// testMantaBackend constructs a manta backend from the environment for
+// use by backend and lock tests.
+func testMantaBackend(t *testing.T) Backend {
+	endpoint := os.Getenv("MANTA_URL")
+	user := os.Getenv("MANTA_USER")
+	keyid := os.Getenv("MANTA_KEY_ID")
+	bucket := os.Getenv("MANTA_TEST_PATH")
+	if bucket == "" {
+		bucket = "vault-manta-testacc-lock"
+	}
+
+	logger := logformat.NewVaultLogger(log.LevelTrace)
+	b, err := NewBackend("manta", logger, map[string]string{
+		"endpoint": endpoint,
+		"keyid":    keyid,
+		"user":     user,
+		"path":     bucket,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	return b
+}
@@ -0,0 +1,91 @@
+package physical
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/helper/logformat"
+	triton "github.com/joyent/triton-go"
+	"github.com/joyent/triton-go/authentication"
+	"github.com/joyent/triton-go/storage"
+	log "github.com/mgutz/logxi/v1"
+)
+
+// TestPutLockConditional_headersReachWire guards against the one failure
+// mode the fake-store race tests in manta_lock_race_test.go can't catch:
+// mutual exclusion in putLockConditional depends entirely on the
+// If-None-Match/If-Match headers in storage.PutObjectInput.Headers making
+// it onto the wire as real HTTP request headers rather than, say, being
+// silently dropped or sent as Manta object metadata instead. This stands
+// up a real triton-go client against a local HTTP server and inspects
+// what the SDK actually sent.
+func TestPutLockConditional_headersReachWire(t *testing.T) {
+	var gotHeaders http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Match") != "" {
+			gotHeaders = r.Header.Clone()
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	})
+	signer, err := authentication.NewPrivateKeySigner(authentication.PrivateKeySignerInput{
+		KeyID:              "test-key",
+		AccountName:        "test-user",
+		PrivateKeyMaterial: keyPEM,
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	client, err := storage.NewClient(&triton.ClientConfig{
+		MantaURL:    server.URL,
+		AccountName: "test-user",
+		Signers:     []authentication.Signer{signer},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	m := &MantaBackend{
+		bucket: "wire-test",
+		client: client,
+		logger: logformat.NewVaultLogger(log.LevelTrace),
+	}
+
+	entry := &mantaLockEntry{Who: "node-a", UUID: "uuid-a"}
+	if err := m.putLockConditional("test/lock", entry, "", true); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	if gotHeaders == nil {
+		t.Fatalf("expected the If-None-Match precondition to reach the server as an HTTP header")
+	}
+	if gotHeaders.Get("If-None-Match") != "*" {
+		t.Fatalf("expected If-None-Match: *, got %q", gotHeaders.Get("If-None-Match"))
+	}
+
+	gotHeaders = nil
+	if err := m.putLockConditional("test/lock", entry, "some-etag", false); err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if gotHeaders == nil {
+		t.Fatalf("expected the If-Match precondition to reach the server as an HTTP header")
+	}
+	if gotHeaders.Get("If-Match") != "some-etag" {
+		t.Fatalf("expected If-Match: some-etag, got %q", gotHeaders.Get("If-Match"))
+	}
+}
@@ -1,18 +1,22 @@
 package physical
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/vault/helper/logformat"
-	"github.com/joyent/gocommon/client"
-	"github.com/joyent/gomanta/manta"
-	"github.com/joyent/gosign/auth"
+	triton "github.com/joyent/triton-go"
+	"github.com/joyent/triton-go/authentication"
+	"github.com/joyent/triton-go/storage"
 	log "github.com/mgutz/logxi/v1"
 )
 
@@ -30,24 +34,29 @@ func TestMantaBackend(t *testing.T) {
 		t.Fatalf("err: %v", err)
 	}
 
-	authentication, err := auth.NewAuth(user, string(privateKey), "rsa-sha256")
+	signer, err := authentication.NewPrivateKeySigner(authentication.PrivateKeySignerInput{
+		KeyID:              keyid,
+		AccountName:        user,
+		PrivateKeyMaterial: privateKey,
+	})
 	if err != nil {
 		t.Fatalf("err: %v", err)
 	}
 
-	credsConfig := &auth.Credentials{
-		UserAuthentication: authentication,
-		MantaKeyId:         keyid,
-		MantaEndpoint:      auth.Endpoint{URL: endpoint},
+	client, err := storage.NewClient(&triton.ClientConfig{
+		MantaURL:    endpoint,
+		AccountName: user,
+		Signers:     []authentication.Signer{signer},
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
 	}
 
-	client := client.NewClient(credsConfig.MantaEndpoint.URL, "", credsConfig, nil)
-	mantaClient := manta.New(client)
-
 	var randInt = rand.New(rand.NewSource(time.Now().UnixNano())).Int()
 	bucket := fmt.Sprintf("vault-manta-testacc-%d", randInt)
 
-	err = mantaClient.PutDirectory(bucket)
+	ctx := context.Background()
+	err = client.Dir().Put(ctx, &storage.PutDirectoryInput{DirectoryName: bucket})
 	if err != nil {
 		t.Fatalf("unable to create test bucket: %s", err)
 	}
@@ -55,9 +64,9 @@ func TestMantaBackend(t *testing.T) {
 	defer func() {
 		// Gotta list all the objects and delete them
 		// before being able to delete the bucket
-		deleteObjects(mantaClient, bucket)
+		deleteObjects(client, bucket)
 
-		err := mantaClient.DeleteDirectory(bucket)
+		err := client.Dir().Delete(ctx, &storage.DeleteDirectoryInput{DirectoryName: bucket})
 		if err != nil {
 			t.Fatalf("err: %s", err)
 		}
@@ -66,8 +75,8 @@ func TestMantaBackend(t *testing.T) {
 	logger := logformat.NewVaultLogger(log.LevelTrace)
 
 	b, err := NewBackend("manta", logger, map[string]string{
-		"endpoint": credsConfig.MantaEndpoint.URL,
-		"keyid":    credsConfig.MantaKeyId,
+		"endpoint": endpoint,
+		"keyid":    keyid,
 		"user":     user,
 		"path":     bucket,
 	})
@@ -79,16 +88,82 @@ func TestMantaBackend(t *testing.T) {
 	testBackend_ListPrefix(t, b)
 }
 
-func deleteObjects(client *manta.Client, dir string) {
-
-	listResp, _ := client.ListDirectory(dir, manta.ListDirectoryOpts{})
-	for _, item := range listResp {
+func deleteObjects(client *storage.StorageClient, dir string) {
+	ctx := context.Background()
+	listResp, _ := client.Dir().List(ctx, &storage.ListDirectoryInput{DirectoryName: dir})
+	if listResp == nil {
+		return
+	}
+	for _, item := range listResp.Entries {
 		if item.Type == "directory" {
 			subDir := path.Join(dir, item.Name)
 			deleteObjects(client, subDir)
-			client.DeleteDirectory(subDir)
+			client.Dir().Delete(ctx, &storage.DeleteDirectoryInput{DirectoryName: subDir})
 		} else {
-			client.DeleteObject(dir, item.Name)
+			client.Objects().Delete(ctx, &storage.DeleteObjectInput{ObjectPath: path.Join(dir, item.Name)})
 		}
 	}
 }
+
+func TestMantaBackend_sealUnseal(t *testing.T) {
+	m := &MantaBackend{
+		encrypt: true,
+		kmsKey:  bytes.Repeat([]byte{0x42}, 32),
+	}
+
+	plaintext := []byte("super secret vault entry")
+
+	sealed, err := m.seal(plaintext)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.HasPrefix(sealed, mantaEncMagic) {
+		t.Fatalf("expected sealed value to carry the envelope magic prefix")
+	}
+
+	opened, err := m.unseal(sealed)
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("expected %q, got %q", plaintext, opened)
+	}
+}
+
+// TestMantaBackend_getIgnoresMagicPrefixWhenEncryptDisabled guards against
+// Get misinterpreting a plaintext object as an encrypted one just because
+// its bytes happen to collide with the envelope magic prefix. Vault's
+// barrier-encrypted values are effectively random, so this is astronomically
+// unlikely in practice, but with 'encrypt' disabled the magic check must
+// never run at all: encryption is off, so nothing Get reads should ever be
+// treated as sealed.
+func TestMantaBackend_getIgnoresMagicPrefixWhenEncryptDisabled(t *testing.T) {
+	plaintext := append(append([]byte{}, mantaEncMagic...), []byte("not actually encrypted")...)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(plaintext)
+	}))
+	defer server.Close()
+
+	client, err := storage.NewClient(&triton.ClientConfig{
+		MantaURL:    server.URL,
+		AccountName: "test-user",
+	})
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+
+	m := &MantaBackend{
+		bucket: "magic-prefix-test",
+		client: client,
+		logger: logformat.NewVaultLogger(log.LevelTrace),
+	}
+
+	entry, err := m.Get("some-key")
+	if err != nil {
+		t.Fatalf("err: %v", err)
+	}
+	if !bytes.Equal(entry.Value, plaintext) {
+		t.Fatalf("expected Get to return the raw bytes unchanged, got %q", entry.Value)
+	}
+}